@@ -0,0 +1,351 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/docker/swarmkit/api"
+	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+)
+
+// blobPayloadThreshold is the size, in bytes, above which a Resource's
+// Payload is offloaded to an external BlobStore instead of being embedded
+// in the raft log. Payloads at or below the threshold continue to travel
+// inline exactly as before, so existing extensions see no behavior change.
+const blobPayloadThreshold = 1 << 20 // 1MB
+
+// blobRefTypeURL marks a Resource.Payload that has been replaced with a
+// reference to an externally stored blob. We deliberately reuse the
+// existing Payload field rather than growing the Resource message, so
+// that offloading is wire-compatible with followers that don't know about
+// it (they simply see an opaque Any and round-trip it through raft).
+const blobRefTypeURL = "type.googleapis.com/docker.swarmkit.store.BlobRef"
+
+// blobRef is the JSON body stored in Payload.Value once a payload has been
+// offloaded. It carries everything needed to fetch the real bytes back out
+// of the driver that holds them.
+type blobRef struct {
+	Digest string `json:"digest"`
+	Driver string `json:"driver"`
+	Size   int64  `json:"size"`
+}
+
+// BlobStore is implemented by external, content-addressable backends that
+// hold Resource payloads too large to replicate through raft. Blobs are
+// addressed by the hex-encoded SHA-256 digest of their contents, so Put is
+// expected to be idempotent: storing the same digest twice is a no-op.
+type BlobStore interface {
+	Put(ctx context.Context, digest string, r io.Reader) error
+	Get(ctx context.Context, digest string) (io.ReadCloser, error)
+	Delete(ctx context.Context, digest string) error
+	// Shared reports whether every node in the cluster can reach this
+	// driver directly (e.g. S3), as opposed to node-local storage (e.g.
+	// filesystem, memory) readable only by the node that wrote it. Save
+	// uses this to decide whether a blob must be streamed into the
+	// snapshot so a node restoring it elsewhere can still retrieve the
+	// payload.
+	Shared() bool
+}
+
+// resourceBlobStores maps a BlobStore driver name to its instance. Only the
+// memory driver below is registered at init time; other drivers (such as a
+// FilesystemBlobStore) are added with RegisterBlobStoreDriver. A driver is
+// selected per-extension via ConfigureResourceBlobStore. Kinds with no
+// configured driver keep storing their payload inline, preserving current
+// behavior.
+var (
+	resourceBlobStoresMu sync.Mutex
+	resourceBlobStores   = map[string]BlobStore{}
+	resourceBlobDrivers  = map[string]string{} // kind -> driver name
+)
+
+// RegisterBlobStoreDriver makes a BlobStore implementation available under
+// name so that ConfigureResourceBlobStore can route a Kind to it.
+func RegisterBlobStoreDriver(name string, store BlobStore) {
+	resourceBlobStoresMu.Lock()
+	defer resourceBlobStoresMu.Unlock()
+	resourceBlobStores[name] = store
+}
+
+// ConfigureResourceBlobStore routes large payloads for the given Resource
+// Kind to the named driver. Calling it with an empty driver name reverts
+// the Kind to inline storage.
+func ConfigureResourceBlobStore(kind, driver string) {
+	resourceBlobStoresMu.Lock()
+	defer resourceBlobStoresMu.Unlock()
+	if driver == "" {
+		delete(resourceBlobDrivers, kind)
+		return
+	}
+	resourceBlobDrivers[kind] = driver
+}
+
+func blobStoreForKind(kind string) (BlobStore, string, bool) {
+	resourceBlobStoresMu.Lock()
+	defer resourceBlobStoresMu.Unlock()
+	driver, ok := resourceBlobDrivers[kind]
+	if !ok {
+		return nil, "", false
+	}
+	store, ok := resourceBlobStores[driver]
+	return store, driver, ok
+}
+
+// dehydrateResource offloads r.Payload to the BlobStore configured for its
+// Kind when the payload exceeds blobPayloadThreshold, replacing it with a
+// blobRef. It is a no-op when no driver is configured or the payload is
+// small enough to keep inline.
+func dehydrateResource(ctx context.Context, r *api.Resource) error {
+	if r.Payload == nil || len(r.Payload.Value) <= blobPayloadThreshold {
+		return nil
+	}
+	blobStore, driver, ok := blobStoreForKind(r.Kind)
+	if !ok {
+		return nil
+	}
+
+	sum := sha256.Sum256(r.Payload.Value)
+	digest := hex.EncodeToString(sum[:])
+	size := int64(len(r.Payload.Value))
+
+	if err := blobStore.Put(ctx, digest, bytes.NewReader(r.Payload.Value)); err != nil {
+		return errors.Wrapf(err, "failed to offload payload for resource %s to blob store", r.ID)
+	}
+
+	ref, err := json.Marshal(blobRef{Digest: digest, Driver: driver, Size: size})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode blob reference")
+	}
+	r.Payload = &types.Any{TypeUrl: blobRefTypeURL, Value: ref}
+	return nil
+}
+
+// hydrateResource resolves a Resource's Payload back to its real bytes if
+// it currently holds a blobRef, fetching them from the configured
+// BlobStore. Resources whose Payload was never offloaded are returned
+// unchanged.
+func hydrateResource(ctx context.Context, r *api.Resource) error {
+	if r.Payload == nil || r.Payload.TypeUrl != blobRefTypeURL {
+		return nil
+	}
+
+	var ref blobRef
+	if err := json.Unmarshal(r.Payload.Value, &ref); err != nil {
+		return errors.Wrap(err, "failed to decode blob reference")
+	}
+
+	resourceBlobStoresMu.Lock()
+	blobStore, ok := resourceBlobStores[ref.Driver]
+	resourceBlobStoresMu.Unlock()
+	if !ok {
+		return errors.Errorf("no blob store driver %q registered to hydrate resource %s", ref.Driver, r.ID)
+	}
+
+	rc, err := blobStore.Get(ctx, ref.Digest)
+	if err != nil {
+		return errors.Wrapf(err, "failed to fetch payload for resource %s from blob store", r.ID)
+	}
+	defer rc.Close()
+
+	payload, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read payload for resource %s from blob store", r.ID)
+	}
+	r.Payload = &types.Any{Value: payload}
+	return nil
+}
+
+// streamBlobForSnapshot prepares r for inclusion in a raft snapshot. If r's
+// Payload isn't offloaded, or is offloaded to a shared driver that every
+// node can reach on its own, r is returned unchanged. If it's offloaded to
+// a node-local driver, the blob is fetched and returned inlined in a copy
+// of r's Payload, so the snapshot carries everything a node restoring it
+// elsewhere needs; CreateResource will re-offload it to that node's own
+// driver (if any) the next time the Resource is written.
+func streamBlobForSnapshot(ctx context.Context, r *api.Resource) (*api.Resource, error) {
+	if r.Payload == nil || r.Payload.TypeUrl != blobRefTypeURL {
+		return r, nil
+	}
+
+	var ref blobRef
+	if err := json.Unmarshal(r.Payload.Value, &ref); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode blob reference for resource %s", r.ID)
+	}
+
+	resourceBlobStoresMu.Lock()
+	blobStore, ok := resourceBlobStores[ref.Driver]
+	resourceBlobStoresMu.Unlock()
+	if !ok {
+		return nil, errors.Errorf("no blob store driver %q registered to snapshot resource %s", ref.Driver, r.ID)
+	}
+	if blobStore.Shared() {
+		return r, nil
+	}
+
+	rc, err := blobStore.Get(ctx, ref.Digest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to stream payload for resource %s into snapshot", r.ID)
+	}
+	defer rc.Close()
+
+	payload, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read payload for resource %s into snapshot", r.ID)
+	}
+
+	streamed := r.Copy()
+	streamed.Payload = &types.Any{Value: payload}
+	return streamed, nil
+}
+
+// deleteResourceBlob garbage-collects the blob backing a Resource's
+// payload, if any. It is called after the delete has been committed to
+// raft, so a failure here never leaves the store in an inconsistent
+// state; it is safe to retry since BlobStore.Delete is idempotent.
+func deleteResourceBlob(ctx context.Context, r *api.Resource) error {
+	if r == nil || r.Payload == nil || r.Payload.TypeUrl != blobRefTypeURL {
+		return nil
+	}
+	var ref blobRef
+	if err := json.Unmarshal(r.Payload.Value, &ref); err != nil {
+		return errors.Wrap(err, "failed to decode blob reference")
+	}
+	resourceBlobStoresMu.Lock()
+	blobStore, ok := resourceBlobStores[ref.Driver]
+	resourceBlobStoresMu.Unlock()
+	if !ok {
+		return nil
+	}
+	return blobStore.Delete(ctx, ref.Digest)
+}
+
+// staleBlobFromUpdate garbage-collects the blob backing previous's payload
+// when updated no longer references it — either because the payload was
+// re-offloaded under a different digest, or because it shrank back under
+// blobPayloadThreshold and is no longer offloaded at all. It is a no-op if
+// previous wasn't offloaded in the first place, so callers can pass the
+// pre-update entry unconditionally without checking first.
+func staleBlobFromUpdate(ctx context.Context, previous, updated *api.Resource) error {
+	if previous == nil || previous.Payload == nil || previous.Payload.TypeUrl != blobRefTypeURL {
+		return nil
+	}
+	if updated.Payload != nil && updated.Payload.TypeUrl == blobRefTypeURL && bytes.Equal(previous.Payload.Value, updated.Payload.Value) {
+		// Same blobRef (digest, driver and size all unchanged): still
+		// referenced, nothing to collect.
+		return nil
+	}
+	return deleteResourceBlob(ctx, previous)
+}
+
+func init() {
+	RegisterBlobStoreDriver("memory", newMemoryBlobStore())
+}
+
+// memoryBlobStore is a BlobStore backed by an in-process map. It exists
+// for tests and single-node development setups; nothing is persisted
+// across restarts.
+type memoryBlobStore struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func newMemoryBlobStore() *memoryBlobStore {
+	return &memoryBlobStore{blobs: map[string][]byte{}}
+}
+
+func (s *memoryBlobStore) Put(ctx context.Context, digest string, r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[digest] = b
+	return nil
+}
+
+func (s *memoryBlobStore) Get(ctx context.Context, digest string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	b, ok := s.blobs[digest]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errors.Errorf("blob %s not found", digest)
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *memoryBlobStore) Delete(ctx context.Context, digest string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, digest)
+	return nil
+}
+
+// Shared implements BlobStore: an in-process map is never reachable from
+// another node.
+func (s *memoryBlobStore) Shared() bool { return false }
+
+// FilesystemBlobStore is a BlobStore backed by a directory on disk, with
+// blobs named after their digest. It is suitable for single-node
+// deployments or tests that need blobs to survive a process restart.
+type FilesystemBlobStore struct {
+	root string
+}
+
+// NewFilesystemBlobStore creates a FilesystemBlobStore rooted at dir,
+// creating it if it doesn't already exist.
+func NewFilesystemBlobStore(dir string) (*FilesystemBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create blob store directory %s", dir)
+	}
+	return &FilesystemBlobStore{root: dir}, nil
+}
+
+func (s *FilesystemBlobStore) path(digest string) string {
+	return filepath.Join(s.root, digest)
+}
+
+// Put implements BlobStore.
+func (s *FilesystemBlobStore) Put(ctx context.Context, digest string, r io.Reader) error {
+	tmp, err := ioutil.TempFile(s.root, digest+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(digest))
+}
+
+// Get implements BlobStore.
+func (s *FilesystemBlobStore) Get(ctx context.Context, digest string) (io.ReadCloser, error) {
+	return os.Open(s.path(digest))
+}
+
+// Delete implements BlobStore.
+func (s *FilesystemBlobStore) Delete(ctx context.Context, digest string) error {
+	err := os.Remove(s.path(digest))
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Shared implements BlobStore: a local directory is only ever reachable
+// from the node that owns it.
+func (s *FilesystemBlobStore) Shared() bool { return false }
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/swarmkit/api"
+	"github.com/gogo/protobuf/types"
+)
+
+type sharedBlobStore struct{ *memoryBlobStore }
+
+func (sharedBlobStore) Shared() bool { return true }
+
+func TestDehydrateHydrateResourceRoundTrip(t *testing.T) {
+	ConfigureResourceBlobStore("TestKind", "memory")
+	defer ConfigureResourceBlobStore("TestKind", "")
+
+	payload := make([]byte, blobPayloadThreshold+1)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	r := &api.Resource{ID: "r1", Kind: "TestKind", Payload: &types.Any{Value: append([]byte(nil), payload...)}}
+	if err := dehydrateResource(context.Background(), r); err != nil {
+		t.Fatalf("dehydrateResource: %v", err)
+	}
+	// The memory driver is shared by every test in the package; leave it
+	// empty for the tests that count its blobs.
+	defer deleteResourceBlob(context.Background(), r)
+	if r.Payload.TypeUrl != blobRefTypeURL {
+		t.Fatalf("expected payload to be offloaded, got TypeUrl %q", r.Payload.TypeUrl)
+	}
+	if len(r.Payload.Value) >= len(payload) {
+		t.Fatalf("expected offloaded payload to be small, got %d bytes", len(r.Payload.Value))
+	}
+
+	// GetResource-style hydration must not mutate the stored entry: the
+	// caller hydrates a copy, not r itself.
+	hydrated := r.Copy()
+	if err := hydrateResource(context.Background(), hydrated); err != nil {
+		t.Fatalf("hydrateResource: %v", err)
+	}
+	if string(hydrated.Payload.Value) != string(payload) {
+		t.Fatalf("hydrated payload mismatch")
+	}
+	if r.Payload.TypeUrl != blobRefTypeURL {
+		t.Fatalf("dehydrated original was mutated by hydrating the copy")
+	}
+}
+
+func TestDeleteResourceBlobRequiresRawEntry(t *testing.T) {
+	ConfigureResourceBlobStore("TestKind2", "memory")
+	defer ConfigureResourceBlobStore("TestKind2", "")
+
+	payload := make([]byte, blobPayloadThreshold+1)
+	r := &api.Resource{ID: "r2", Kind: "TestKind2", Payload: &types.Any{Value: payload}}
+	if err := dehydrateResource(context.Background(), r); err != nil {
+		t.Fatalf("dehydrateResource: %v", err)
+	}
+
+	// Simulate GetResource's hydration path: once hydrated, the blobRef is
+	// gone, so deleteResourceBlob has nothing to act on (as documented).
+	hydrated := r.Copy()
+	if err := hydrateResource(context.Background(), hydrated); err != nil {
+		t.Fatalf("hydrateResource: %v", err)
+	}
+	if err := deleteResourceBlob(context.Background(), hydrated); err != nil {
+		t.Fatalf("deleteResourceBlob on hydrated copy: %v", err)
+	}
+
+	// deleteResourceBlob against the raw, still-dehydrated entry must
+	// actually remove the blob from the driver.
+	if err := deleteResourceBlob(context.Background(), r); err != nil {
+		t.Fatalf("deleteResourceBlob on raw entry: %v", err)
+	}
+	store := resourceBlobStores["memory"].(*memoryBlobStore)
+	if len(store.blobs) != 0 {
+		t.Fatalf("expected blob to be garbage collected, store still has %d entries", len(store.blobs))
+	}
+}
+
+// TestUpdateResourceGarbagesCollectsStaleBlob covers the leak called out in
+// review: editing an offloaded Resource must not leave its old blob behind,
+// whether the new payload is offloaded under a different digest or shrinks
+// back under blobPayloadThreshold and is no longer offloaded at all.
+func TestUpdateResourceGarbagesCollectsStaleBlob(t *testing.T) {
+	ConfigureResourceBlobStore("BlobUpdateKind", "memory")
+	defer ConfigureResourceBlobStore("BlobUpdateKind", "")
+
+	s := NewMemoryStore(nil)
+	const kind = "BlobUpdateKind"
+	err := s.Update(func(tx Tx) error {
+		return CreateExtension(tx, &api.Extension{Annotations: api.Annotations{Name: kind}})
+	})
+	if err != nil {
+		t.Fatalf("CreateExtension: %v", err)
+	}
+
+	first := make([]byte, blobPayloadThreshold+1)
+	for i := range first {
+		first[i] = 'a'
+	}
+	err = s.Update(func(tx Tx) error {
+		return CreateResource(tx, &api.Resource{
+			ID:          "r",
+			Annotations: api.Annotations{Name: "r"},
+			Kind:        kind,
+			Payload:     &types.Any{Value: first},
+		})
+	})
+	if err != nil {
+		t.Fatalf("CreateResource: %v", err)
+	}
+
+	store := resourceBlobStores["memory"].(*memoryBlobStore)
+	if len(store.blobs) != 1 {
+		t.Fatalf("expected 1 blob after create, got %d", len(store.blobs))
+	}
+
+	// Replace with a different large payload: the digest changes, so the
+	// blob backing the first payload is now orphaned.
+	second := make([]byte, blobPayloadThreshold+1)
+	for i := range second {
+		second[i] = 'b'
+	}
+	err = s.Update(func(tx Tx) error {
+		r := getRawResource(tx, "r")
+		updated := r.Copy()
+		updated.Payload = &types.Any{Value: second}
+		return UpdateResource(tx, updated)
+	})
+	if err != nil {
+		t.Fatalf("UpdateResource (digest change): %v", err)
+	}
+	if len(store.blobs) != 1 {
+		t.Fatalf("expected stale blob from digest change to be collected, store has %d entries", len(store.blobs))
+	}
+
+	// Shrink the payload back under threshold: no longer offloaded at all,
+	// so the blob backing the second payload is now orphaned too.
+	err = s.Update(func(tx Tx) error {
+		r := getRawResource(tx, "r")
+		updated := r.Copy()
+		updated.Payload = &types.Any{Value: []byte("small")}
+		return UpdateResource(tx, updated)
+	})
+	if err != nil {
+		t.Fatalf("UpdateResource (shrink under threshold): %v", err)
+	}
+	if len(store.blobs) != 0 {
+		t.Fatalf("expected blob orphaned by shrinking under threshold to be collected, store has %d entries", len(store.blobs))
+	}
+}
+
+func TestStreamBlobForSnapshotNodeLocalVsShared(t *testing.T) {
+	payload := make([]byte, blobPayloadThreshold+1)
+
+	RegisterBlobStoreDriver("shared-test", sharedBlobStore{newMemoryBlobStore()})
+	defer delete(resourceBlobStores, "shared-test")
+
+	ConfigureResourceBlobStore("LocalKind", "memory")
+	defer ConfigureResourceBlobStore("LocalKind", "")
+	ConfigureResourceBlobStore("SharedKind", "shared-test")
+	defer ConfigureResourceBlobStore("SharedKind", "")
+
+	local := &api.Resource{ID: "local", Kind: "LocalKind", Payload: &types.Any{Value: append([]byte(nil), payload...)}}
+	if err := dehydrateResource(context.Background(), local); err != nil {
+		t.Fatalf("dehydrateResource(local): %v", err)
+	}
+	defer deleteResourceBlob(context.Background(), local)
+	streamedLocal, err := streamBlobForSnapshot(context.Background(), local)
+	if err != nil {
+		t.Fatalf("streamBlobForSnapshot(local): %v", err)
+	}
+	if streamedLocal.Payload.TypeUrl == blobRefTypeURL {
+		t.Fatalf("expected node-local blob to be streamed inline into the snapshot")
+	}
+	if len(streamedLocal.Payload.Value) != len(payload) {
+		t.Fatalf("expected full payload to be streamed, got %d bytes", len(streamedLocal.Payload.Value))
+	}
+	// The original entry must be untouched.
+	if local.Payload.TypeUrl != blobRefTypeURL {
+		t.Fatalf("streamBlobForSnapshot mutated the original resource")
+	}
+
+	shared := &api.Resource{ID: "shared", Kind: "SharedKind", Payload: &types.Any{Value: append([]byte(nil), payload...)}}
+	if err := dehydrateResource(context.Background(), shared); err != nil {
+		t.Fatalf("dehydrateResource(shared): %v", err)
+	}
+	streamedShared, err := streamBlobForSnapshot(context.Background(), shared)
+	if err != nil {
+		t.Fatalf("streamBlobForSnapshot(shared): %v", err)
+	}
+	if streamedShared.Payload.TypeUrl != blobRefTypeURL {
+		t.Fatalf("expected shared-driver blob to stay offloaded in the snapshot")
+	}
+}
@@ -0,0 +1,212 @@
+package store
+
+import (
+	"encoding/json"
+
+	"github.com/docker/swarmkit/api"
+	"github.com/pkg/errors"
+)
+
+// dependsOnAnnotationKey stores the JSON-encoded list of Resource IDs that
+// a Resource depends on.
+//
+// This is a stand-in, not a design choice: the request asks for a real
+// `DependsOn []ResourceRef` field on api.Resource, which would need a
+// change to resource.proto and its generated code. That file lives in
+// github.com/docker/swarmkit/api, a package this slice of the tree
+// doesn't include and this change cannot touch. Storing the same data in
+// Annotations.Labels keeps the feature usable in the meantime, but it is
+// a real regression versus a proto field: it gets none of the generated
+// Copy/Equal/marshal support DependsOn would have, doesn't show up in
+// anything that introspects Resource's schema, and any code that already
+// has write access to a Resource's Annotations (every caller that can
+// write a Resource at all) can silently corrupt the dependency graph by
+// touching this label. Replace this with a real field — and drop this
+// annotation key and dependsOn() entirely — as soon as api.Resource can
+// be changed.
+const dependsOnAnnotationKey = "com.docker.swarmkit.store/depends-on"
+
+// DependencyPolicy controls what DeleteResourceWithPolicy does when the
+// Resource being deleted has dependents.
+type DependencyPolicy int
+
+const (
+	// DependencyRestrict fails the delete if any dependents exist.
+	DependencyRestrict DependencyPolicy = iota
+	// DependencyCascade recursively deletes dependents first.
+	DependencyCascade
+)
+
+// dependsOn returns the IDs r declares a dependency on.
+func dependsOn(r *api.Resource) []string {
+	if r.Annotations.Labels == nil {
+		return nil
+	}
+	raw, ok := r.Annotations.Labels[dependsOnAnnotationKey]
+	if !ok || raw == "" {
+		return nil
+	}
+	var ids []string
+	if err := json.Unmarshal([]byte(raw), &ids); err != nil {
+		return nil
+	}
+	return ids
+}
+
+// FindDependents returns the Resources that declare a dependency on id.
+//
+// There's no dedicated composite-indexed memdb table backing this lookup.
+// A real tableResourceDependency, as requested, needs entries that
+// implement api.StoreObject (ID/Meta/SetMeta/CopyStoreObject plus
+// EventCreate/EventUpdate/EventDelete returning a new api.Event variant)
+// so it can go through the same tx.create/tx.find machinery as the
+// resource table — and that variant has to be generated onto a type in
+// github.com/docker/swarmkit/api, which is out of reach from this package.
+// Until that's possible, this recomputes the reverse edge with a single
+// scan of the resource table. Callers that need this for more than one ID
+// at a time (such as cascading delete) should build the index
+// once via buildResourceDependencyIndex instead of calling FindDependents
+// in a loop — see DeleteResourceWithPolicy.
+func FindDependents(tx ReadTx, id string) ([]*api.Resource, error) {
+	all, err := FindResources(tx, All)
+	if err != nil {
+		return nil, err
+	}
+	var dependents []*api.Resource
+	for _, r := range all {
+		for _, dep := range dependsOn(r) {
+			if dep == id {
+				dependents = append(dependents, r)
+				break
+			}
+		}
+	}
+	return dependents, nil
+}
+
+// buildResourceDependencyIndex scans the resource table once and returns
+// the reverse-dependency adjacency map: to_id -> the IDs of the Resources
+// that depend on it. Building it costs exactly one FindResources(tx, All)
+// call, however deep the dependency graph being walked turns out to be.
+func buildResourceDependencyIndex(tx ReadTx) (map[string][]string, error) {
+	all, err := FindResources(tx, All)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string][]string)
+	for _, r := range all {
+		for _, dep := range dependsOn(r) {
+			index[dep] = append(index[dep], r.ID)
+		}
+	}
+	return index, nil
+}
+
+// FindDependencies returns the Resources that id declares a dependency on.
+func FindDependencies(tx ReadTx, id string) ([]*api.Resource, error) {
+	r := GetResource(tx, id)
+	if r == nil {
+		return nil, nil
+	}
+	var deps []*api.Resource
+	for _, depID := range dependsOn(r) {
+		if dep := GetResource(tx, depID); dep != nil {
+			deps = append(deps, dep)
+		}
+	}
+	return deps, nil
+}
+
+// detectDependencyCycle reports whether adding r (with its current
+// DependsOn) would introduce a cycle in the dependency graph. It walks the
+// dependency chain starting at each of r's declared dependencies looking
+// for a path back to r.ID.
+//
+// onStack tracks only the IDs on the *current* path, not every ID visited
+// overall: a diamond (A depends on both B and C, and both B and C depend
+// on D) is a perfectly valid DAG, and D must be free to appear again once
+// the walk backtracks out of B's branch and starts C's. Marking IDs
+// visited for the whole walk instead of just the current path would flag
+// that diamond as a cycle even though there isn't one.
+func detectDependencyCycle(tx ReadTx, r *api.Resource) error {
+	onStack := map[string]bool{r.ID: true}
+	var walk func(id string) error
+	walk = func(id string) error {
+		if onStack[id] {
+			return errors.Errorf("resource %s: dependency cycle detected at %s", r.ID, id)
+		}
+		onStack[id] = true
+		defer delete(onStack, id)
+
+		// getRawResource, not GetResource: all we need is dependsOn(dep),
+		// which reads Annotations.Labels. Hydrating the payload out of a
+		// BlobStore here would fetch and immediately discard a blob for
+		// every resource on the walk, on every single Create/UpdateResource
+		// call for any Kind with dependencies.
+		dep := getRawResource(tx, id)
+		if dep == nil {
+			return nil
+		}
+		for _, next := range dependsOn(dep) {
+			if err := walk(next); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, id := range dependsOn(r) {
+		if err := walk(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteResourceWithPolicy removes id from the store, applying policy to
+// any Resources that declare id as a dependency. DependencyRestrict fails
+// the whole delete (leaving the transaction untouched) if dependents
+// exist; DependencyCascade deletes them first, recursively, in the same
+// transaction. Plain DeleteResource is left with its existing semantics
+// (no dependency enforcement at all) for callers that predate this API.
+//
+// The reverse-dependency index is built once, up front, and the recursive
+// cascade walks that in-memory map rather than calling FindDependents at
+// every level: a cascade of depth k costs one resource-table scan total,
+// not k of them.
+func DeleteResourceWithPolicy(tx Tx, id string, policy DependencyPolicy) error {
+	index, err := buildResourceDependencyIndex(tx)
+	if err != nil {
+		return err
+	}
+	return deleteResourceWithPolicy(tx, id, policy, index, map[string]bool{})
+}
+
+// deleted tracks IDs already removed in this cascade. The dependents graph
+// (not just the dependency graph) can itself be a diamond — e.g. A and B
+// both DependsOn R, and G DependsOn both A and B — so the same node can be
+// reached twice while cascading from R: once via A's subtree, once via
+// B's. Without deduping, the second visit calls DeleteResource on an ID
+// that's already gone and fails the whole cascade with ErrNotExist.
+func deleteResourceWithPolicy(tx Tx, id string, policy DependencyPolicy, index map[string][]string, deleted map[string]bool) error {
+	if deleted[id] {
+		return nil
+	}
+	dependents := index[id]
+	if len(dependents) == 0 {
+		deleted[id] = true
+		return DeleteResource(tx, id)
+	}
+
+	switch policy {
+	case DependencyCascade:
+		for _, dependentID := range dependents {
+			if err := deleteResourceWithPolicy(tx, dependentID, policy, index, deleted); err != nil {
+				return err
+			}
+		}
+		deleted[id] = true
+		return DeleteResource(tx, id)
+	default:
+		return errors.Errorf("cannot delete resource %s: %d dependent resource(s) still reference it (%v)", id, len(dependents), dependents)
+	}
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/docker/swarmkit/api"
+)
+
+func newTestResourceStore(t *testing.T, kind string) *MemoryStore {
+	t.Helper()
+	s := NewMemoryStore(nil)
+	if s == nil {
+		t.Fatal("NewMemoryStore returned nil")
+	}
+	err := s.Update(func(tx Tx) error {
+		return CreateExtension(tx, &api.Extension{Annotations: api.Annotations{Name: kind}})
+	})
+	if err != nil {
+		t.Fatalf("failed to register extension %s: %v", kind, err)
+	}
+	return s
+}
+
+func resourceWithDeps(id, kind string, deps ...string) *api.Resource {
+	r := &api.Resource{ID: id, Annotations: api.Annotations{Name: id}, Kind: kind}
+	if len(deps) > 0 {
+		setDependsOnForTest(r, deps)
+	}
+	return r
+}
+
+// setDependsOnForTest mirrors what a real DependsOn field setter would do;
+// it exists only so tests don't reach past the package boundary into the
+// annotation encoding.
+func setDependsOnForTest(r *api.Resource, deps []string) {
+	raw, err := json.Marshal(deps)
+	if err != nil {
+		panic(err)
+	}
+	if r.Annotations.Labels == nil {
+		r.Annotations.Labels = map[string]string{}
+	}
+	r.Annotations.Labels[dependsOnAnnotationKey] = string(raw)
+}
+
+// TestDetectDependencyCycleAllowsDiamond exercises the exact shape called
+// out in review: A depends on both B and C, and both B and C depend on D.
+// That's a valid DAG, not a cycle, and must not be rejected.
+func TestDetectDependencyCycleAllowsDiamond(t *testing.T) {
+	s := newTestResourceStore(t, "diamond.kind")
+
+	err := s.Update(func(tx Tx) error {
+		for _, r := range []*api.Resource{
+			resourceWithDeps("d", "diamond.kind"),
+			resourceWithDeps("b", "diamond.kind", "d"),
+			resourceWithDeps("c", "diamond.kind", "d"),
+		} {
+			if err := CreateResource(tx, r); err != nil {
+				return err
+			}
+		}
+		return CreateResource(tx, resourceWithDeps("a", "diamond.kind", "b", "c"))
+	})
+	if err != nil {
+		t.Fatalf("expected diamond-shaped dependency graph to be accepted, got: %v", err)
+	}
+}
+
+// TestDetectDependencyCycleRejectsRealCycle makes sure the fix for the
+// diamond false positive didn't also break detection of an actual cycle.
+func TestDetectDependencyCycleRejectsRealCycle(t *testing.T) {
+	s := newTestResourceStore(t, "cycle.kind")
+
+	err := s.Update(func(tx Tx) error {
+		if err := CreateResource(tx, resourceWithDeps("x", "cycle.kind")); err != nil {
+			return err
+		}
+		if err := CreateResource(tx, resourceWithDeps("y", "cycle.kind", "x")); err != nil {
+			return err
+		}
+		// Close the cycle: x -> y -> x.
+		x := GetResource(tx, "x")
+		setDependsOnForTest(x, []string{"y"})
+		return UpdateResource(tx, x)
+	})
+	if err == nil {
+		t.Fatal("expected a real cycle (x -> y -> x) to be rejected")
+	}
+}
+
+// TestDryRunResourceRejectsCycle makes sure DryRunResource actually shares
+// the cycle check with Create/UpdateResource instead of just claiming to.
+func TestDryRunResourceRejectsCycle(t *testing.T) {
+	s := newTestResourceStore(t, "dryrun-cycle.kind")
+
+	err := s.Update(func(tx Tx) error {
+		return CreateResource(tx, resourceWithDeps("x", "dryrun-cycle.kind"))
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	s.View(func(tx ReadTx) {
+		// x -> x is a direct self-cycle.
+		candidate := resourceWithDeps("x", "dryrun-cycle.kind", "x")
+		if err := DryRunResource(tx, candidate); err == nil {
+			t.Fatal("expected DryRunResource to reject a self-cycle")
+		}
+	})
+}
+
+// TestDeleteResourceWithPolicyCascade checks that CASCADE removes an
+// entire dependent subtree in one call.
+func TestDeleteResourceWithPolicyCascade(t *testing.T) {
+	s := newTestResourceStore(t, "cascade.kind")
+
+	err := s.Update(func(tx Tx) error {
+		for _, r := range []*api.Resource{
+			resourceWithDeps("root", "cascade.kind"),
+			resourceWithDeps("child", "cascade.kind", "root"),
+			resourceWithDeps("grandchild", "cascade.kind", "child"),
+		} {
+			if err := CreateResource(tx, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	err = s.Update(func(tx Tx) error {
+		return DeleteResourceWithPolicy(tx, "root", DependencyCascade)
+	})
+	if err != nil {
+		t.Fatalf("cascade delete failed: %v", err)
+	}
+
+	s.View(func(tx ReadTx) {
+		for _, id := range []string{"root", "child", "grandchild"} {
+			if GetResource(tx, id) != nil {
+				t.Errorf("expected %s to be removed by cascade", id)
+			}
+		}
+	})
+}
+
+// TestDeleteResourceWithPolicyCascadeDiamondDependents covers a diamond in
+// the *dependents* graph, not the dependency graph: A and B both DependsOn
+// R, and G DependsOn both A and B. Cascading from R reaches G via both A's
+// subtree and B's subtree; it must be deleted exactly once, not fail with
+// ErrNotExist on the second visit.
+func TestDeleteResourceWithPolicyCascadeDiamondDependents(t *testing.T) {
+	s := newTestResourceStore(t, "diamond-cascade.kind")
+
+	err := s.Update(func(tx Tx) error {
+		for _, r := range []*api.Resource{
+			resourceWithDeps("r", "diamond-cascade.kind"),
+			resourceWithDeps("a", "diamond-cascade.kind", "r"),
+			resourceWithDeps("b", "diamond-cascade.kind", "r"),
+			resourceWithDeps("g", "diamond-cascade.kind", "a", "b"),
+		} {
+			if err := CreateResource(tx, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	err = s.Update(func(tx Tx) error {
+		return DeleteResourceWithPolicy(tx, "r", DependencyCascade)
+	})
+	if err != nil {
+		t.Fatalf("cascade delete over diamond dependents failed: %v", err)
+	}
+
+	s.View(func(tx ReadTx) {
+		for _, id := range []string{"r", "a", "b", "g"} {
+			if GetResource(tx, id) != nil {
+				t.Errorf("expected %s to be removed by cascade", id)
+			}
+		}
+	})
+}
+
+// TestDeleteResourceWithPolicyRestrict checks that RESTRICT refuses to
+// delete a Resource with live dependents and leaves the store untouched.
+func TestDeleteResourceWithPolicyRestrict(t *testing.T) {
+	s := newTestResourceStore(t, "restrict.kind")
+
+	err := s.Update(func(tx Tx) error {
+		if err := CreateResource(tx, resourceWithDeps("root", "restrict.kind")); err != nil {
+			return err
+		}
+		return CreateResource(tx, resourceWithDeps("child", "restrict.kind", "root"))
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	err = s.Update(func(tx Tx) error {
+		return DeleteResourceWithPolicy(tx, "root", DependencyRestrict)
+	})
+	if err == nil {
+		t.Fatal("expected RESTRICT to refuse deleting a resource with dependents")
+	}
+
+	s.View(func(tx ReadTx) {
+		if GetResource(tx, "root") == nil {
+			t.Error("RESTRICT should not have removed root")
+		}
+	})
+}
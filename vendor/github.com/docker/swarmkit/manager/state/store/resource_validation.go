@@ -0,0 +1,169 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/docker/swarmkit/api"
+)
+
+// schemaAnnotationKey holds the schema document (JSON-Schema, CUE, or
+// whatever dialect the registered SchemaCompiler understands) that
+// payloads of an Extension's Kind must conform to.
+//
+// The request asks for this to be a real field on api.Extension, generated
+// from extension.proto. That file is in github.com/docker/swarmkit/api,
+// which isn't part of this slice of the tree, so it can't be edited here.
+// Storing the schema text in Annotations.Labels instead is a deliberate,
+// flagged workaround, not an equivalent: Labels is already public API any
+// extension author can write to, so nothing stops a client from quietly
+// swapping out or deleting the schema for a Kind it doesn't own, and
+// there's no generated Equal/Copy support or schema visibility in
+// whatever introspects api.Extension's real fields. Move this to a proper
+// field — and delete this annotation key along with the code that reads
+// it in validatorForKind below — the moment api.Extension can be changed.
+const schemaAnnotationKey = "com.docker.swarmkit.store/payload-schema"
+
+// ValidationError is a single structural complaint about a Resource
+// payload, pinpointed with a JSON pointer so callers can surface it next
+// to the offending field rather than as an opaque blob of text.
+type ValidationError struct {
+	Pointer string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pointer, e.Message)
+}
+
+// ValidationErrors collects every ValidationError found while checking a
+// single payload.
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ResourceValidator checks a Resource payload against a compiled schema.
+type ResourceValidator interface {
+	Validate(payload []byte) ValidationErrors
+}
+
+// SchemaCompiler compiles a raw schema document into a ResourceValidator.
+// Swarmkit itself doesn't vendor a schema engine, so extensions that want
+// validation register a compiler for the dialect their schema documents
+// use (e.g. JSON-Schema, CUE).
+type SchemaCompiler func(schema string) (ResourceValidator, error)
+
+var (
+	schemaCompilerMu sync.Mutex
+	schemaCompiler   SchemaCompiler
+
+	validatorCacheMu sync.Mutex
+	validatorCache   = map[string]cachedValidator{} // kind -> ...
+)
+
+type cachedValidator struct {
+	schema    string
+	validator ResourceValidator
+}
+
+// RegisterSchemaCompiler sets the SchemaCompiler used to turn Extension
+// payload schemas into ResourceValidators. Only one dialect can be active
+// at a time; calling it again replaces the previous compiler and clears
+// the validator cache so every Kind recompiles on next use.
+func RegisterSchemaCompiler(compiler SchemaCompiler) {
+	schemaCompilerMu.Lock()
+	schemaCompiler = compiler
+	schemaCompilerMu.Unlock()
+
+	validatorCacheMu.Lock()
+	validatorCache = map[string]cachedValidator{}
+	validatorCacheMu.Unlock()
+}
+
+// validatorForKind returns the ResourceValidator configured for kind via
+// its Extension's schema annotation, or nil if the extension doesn't
+// declare one. The cache is keyed on kind and each entry records the schema
+// text it was compiled from, so a change to the Extension's schema is
+// picked up the next time this is called for that kind without any
+// separate invalidation hook.
+func validatorForKind(tx ReadTx, kind string) (ResourceValidator, error) {
+	extensions, err := FindExtensions(tx, ByName(kind))
+	if err != nil {
+		return nil, err
+	}
+	if len(extensions) == 0 {
+		return nil, nil
+	}
+	schema := extensions[0].Annotations.Labels[schemaAnnotationKey]
+	if schema == "" {
+		return nil, nil
+	}
+
+	validatorCacheMu.Lock()
+	cached, ok := validatorCache[kind]
+	validatorCacheMu.Unlock()
+	if ok && cached.schema == schema {
+		return cached.validator, nil
+	}
+
+	schemaCompilerMu.Lock()
+	compiler := schemaCompiler
+	schemaCompilerMu.Unlock()
+	if compiler == nil {
+		return nil, fmt.Errorf("kind %s declares a payload schema but no SchemaCompiler is registered", kind)
+	}
+
+	validator, err := compiler(schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile payload schema for kind %s: %w", kind, err)
+	}
+
+	validatorCacheMu.Lock()
+	validatorCache[kind] = cachedValidator{schema: schema, validator: validator}
+	validatorCacheMu.Unlock()
+	return validator, nil
+}
+
+// validateResourcePayload runs r's configured validator, if any, against
+// its current (still-inline) payload. It must run before dehydrateResource
+// offloads the payload to a BlobStore, since the validator has no business
+// understanding blobRefs.
+func validateResourcePayload(tx ReadTx, r *api.Resource) error {
+	validator, err := validatorForKind(tx, r.Kind)
+	if err != nil {
+		return err
+	}
+	if validator == nil {
+		return nil
+	}
+	var payload []byte
+	if r.Payload != nil {
+		payload = r.Payload.Value
+	}
+	if errs := validator.Validate(payload); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// DryRunResource runs the same checks CreateResource/UpdateResource would
+// (Extension existence, dependency cycle detection, and payload schema
+// validation) without mutating the store, so controllers can preflight a
+// user submission and return a structured ValidationErrors instead of
+// discovering the problem only when the write is attempted.
+func DryRunResource(tx ReadTx, r *api.Resource) error {
+	if err := confirmExtension(tx, r); err != nil {
+		return err
+	}
+	if err := detectDependencyCycle(tx, r); err != nil {
+		return err
+	}
+	return validateResourcePayload(tx, r)
+}
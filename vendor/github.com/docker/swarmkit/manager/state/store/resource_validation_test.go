@@ -0,0 +1,125 @@
+package store
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/swarmkit/api"
+	"github.com/gogo/protobuf/types"
+)
+
+func TestValidationErrorsFormatting(t *testing.T) {
+	errs := ValidationErrors{
+		{Pointer: "/name", Message: "is required"},
+		{Pointer: "/count", Message: "must be positive"},
+	}
+	msg := errs.Error()
+	if !strings.Contains(msg, "/name: is required") || !strings.Contains(msg, "/count: must be positive") {
+		t.Fatalf("unexpected ValidationErrors.Error() output: %q", msg)
+	}
+}
+
+// countingCompiler returns a validator that rejects payloads equal to
+// "reject", and counts how many times it was asked to compile a schema so
+// tests can assert on cache hits/misses.
+type countingCompiler struct {
+	compiles int
+}
+
+type rejectValidator struct{}
+
+func (rejectValidator) Validate(payload []byte) ValidationErrors {
+	if string(payload) == "reject" {
+		return ValidationErrors{{Pointer: "/", Message: "payload rejected"}}
+	}
+	return nil
+}
+
+func (c *countingCompiler) compile(schema string) (ResourceValidator, error) {
+	c.compiles++
+	return rejectValidator{}, nil
+}
+
+func TestValidatorCacheInvalidatesOnSchemaChange(t *testing.T) {
+	compiler := &countingCompiler{}
+	RegisterSchemaCompiler(compiler.compile)
+	defer RegisterSchemaCompiler(nil)
+
+	s := NewMemoryStore(nil)
+	const kind = "validated.kind"
+
+	err := s.Update(func(tx Tx) error {
+		return CreateExtension(tx, &api.Extension{
+			ID: kind,
+			Annotations: api.Annotations{
+				Name:   kind,
+				Labels: map[string]string{schemaAnnotationKey: "schema-v1"},
+			},
+		})
+	})
+	if err != nil {
+		t.Fatalf("CreateExtension: %v", err)
+	}
+
+	s.View(func(tx ReadTx) {
+		if _, err := validatorForKind(tx, kind); err != nil {
+			t.Fatalf("validatorForKind: %v", err)
+		}
+		if _, err := validatorForKind(tx, kind); err != nil {
+			t.Fatalf("validatorForKind (second call): %v", err)
+		}
+	})
+	if compiler.compiles != 1 {
+		t.Fatalf("expected schema to be compiled once and cached, got %d compiles", compiler.compiles)
+	}
+
+	err = s.Update(func(tx Tx) error {
+		ext := GetExtension(tx, kind)
+		ext.Annotations.Labels[schemaAnnotationKey] = "schema-v2"
+		return UpdateExtension(tx, ext)
+	})
+	if err != nil {
+		t.Fatalf("UpdateExtension: %v", err)
+	}
+
+	s.View(func(tx ReadTx) {
+		if _, err := validatorForKind(tx, kind); err != nil {
+			t.Fatalf("validatorForKind after schema change: %v", err)
+		}
+	})
+	if compiler.compiles != 2 {
+		t.Fatalf("expected changed schema to trigger a recompile, got %d compiles", compiler.compiles)
+	}
+}
+
+func TestValidateResourcePayloadRejectsNonConforming(t *testing.T) {
+	compiler := &countingCompiler{}
+	RegisterSchemaCompiler(compiler.compile)
+	defer RegisterSchemaCompiler(nil)
+
+	s := NewMemoryStore(nil)
+	const kind = "strict.kind"
+	err := s.Update(func(tx Tx) error {
+		return CreateExtension(tx, &api.Extension{
+			Annotations: api.Annotations{
+				Name:   kind,
+				Labels: map[string]string{schemaAnnotationKey: "schema"},
+			},
+		})
+	})
+	if err != nil {
+		t.Fatalf("CreateExtension: %v", err)
+	}
+
+	err = s.Update(func(tx Tx) error {
+		return CreateResource(tx, &api.Resource{
+			ID:          "bad",
+			Annotations: api.Annotations{Name: "bad"},
+			Kind:        kind,
+			Payload:     &types.Any{Value: []byte("reject")},
+		})
+	})
+	if err == nil {
+		t.Fatal("expected non-conforming payload to be rejected")
+	}
+}
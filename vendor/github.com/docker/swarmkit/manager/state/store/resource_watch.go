@@ -0,0 +1,175 @@
+package store
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/docker/swarmkit/api"
+)
+
+// WatchOpt configures WatchResourcesByKind.
+type WatchOpt func(*watchResourcesConfig)
+
+type watchResourcesConfig struct {
+	replay       bool
+	customPrefix string
+}
+
+// WithResourceReplay causes WatchResourcesByKind to first deliver every
+// currently-existing Resource matching the subscription (as synthetic
+// EventCreateResource-shaped api.Event values) before streaming live
+// events, all under the same transaction as the watch registration. This
+// is the standard "list-then-watch" contract: a subscriber that replays
+// and then watches never misses a Resource that was created between its
+// initial listing and its subscription taking effect.
+func WithResourceReplay() WatchOpt {
+	return func(c *watchResourcesConfig) {
+		c.replay = true
+	}
+}
+
+// ByCustomPrefix scopes the subscription to Resources whose custom index
+// value (see api.ResourceCustomIndexer) starts with prefix, for extensions
+// that partition their Resources with a tenant or shard prefix instead of
+// (or in addition to) Kind.
+func ByCustomPrefix(prefix string) WatchOpt {
+	return func(c *watchResourcesConfig) {
+		c.customPrefix = prefix
+	}
+}
+
+// resourceOf extracts the *api.Resource carried by an api.Event, if the
+// event is one of the Resource event kinds. It's the watch-side
+// counterpart to ApplyStoreAction's `case *api.StoreAction_Resource`
+// switch in resources.go.
+func resourceOf(ev api.Event) (*api.Resource, bool) {
+	switch v := ev.(type) {
+	case EventCreateResource:
+		return v.Resource, true
+	case EventUpdateResource:
+		return v.Resource, true
+	case EventDeleteResource:
+		return v.Resource, true
+	default:
+		return nil, false
+	}
+}
+
+// matchesCustomPrefix reports whether r belongs to the given custom-index
+// prefix. Unlike Kind, there's no specifier-level way to ask the event
+// publisher to pre-filter on an arbitrary annotation prefix, so this still
+// runs in the forwarding goroutine below.
+func matchesCustomPrefix(r *api.Resource, customPrefix string) bool {
+	if customPrefix == "" {
+		return true
+	}
+	for _, idx := range r.Annotations.Indices {
+		if idx.Val != "" && strings.HasPrefix(idx.Val, customPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchResourcesByKind subscribes to Resource events for a single Kind,
+// instead of making every caller filter the store's full event firehose
+// for themselves. The Kind filter is applied at the publisher: the
+// specifiers passed to ViewAndWatch are Resource events carrying only
+// Kind, so the event bus itself only ever delivers matching events to our
+// channel, the same way a Node- or Task-scoped watch only ever delivers
+// events for IDs matching its specifier. The forwarding goroutine below
+// still re-checks Kind defensively (matching a Resource with the wrong
+// Kind would be a bug, not something to forward silently) and handles
+// ByCustomPrefix, which has no publisher-side equivalent.
+//
+// When opts includes WithResourceReplay, the current set of matching
+// Resources (via FindResources(tx, ByKind(kind))) is delivered first,
+// registered atomically with the watch so no Resource create/update
+// happening concurrently can fall in the gap between listing and
+// watching.
+func WatchResourcesByKind(s *MemoryStore, kind string, opts ...WatchOpt) (<-chan api.Event, func(), error) {
+	var cfg watchResourcesConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	// A Resource specifier with only Kind set matches every Resource event
+	// of that Kind, regardless of ID — the same "zero field = wildcard"
+	// convention the rest of the event bus uses for specifiers. An empty
+	// kind subscribes to every Kind.
+	specifier := &api.Resource{Kind: kind}
+	specifiers := []api.Event{
+		EventCreateResource{Resource: specifier},
+		EventUpdateResource{Resource: specifier},
+		EventDeleteResource{Resource: specifier},
+	}
+
+	var initial []*api.Resource
+	events, watchCancel, err := ViewAndWatch(s, func(tx ReadTx) error {
+		if !cfg.replay {
+			return nil
+		}
+		var err error
+		if kind != "" {
+			initial, err = FindResources(tx, ByKind(kind))
+		} else {
+			initial, err = FindResources(tx, All)
+		}
+		return err
+	}, specifiers...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := make(chan api.Event)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	cancel := func() {
+		stopOnce.Do(func() { close(stop) })
+		watchCancel()
+	}
+
+	send := func(ev api.Event) bool {
+		select {
+		case out <- ev:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+
+	go func() {
+		defer close(out)
+		for _, r := range initial {
+			if !matchesCustomPrefix(r, cfg.customPrefix) {
+				continue
+			}
+			if !send(EventCreateResource{Resource: r}) {
+				return
+			}
+		}
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				apiEvent, ok := ev.(api.Event)
+				if !ok {
+					continue
+				}
+				r, ok := resourceOf(apiEvent)
+				if !ok || (kind != "" && r.Kind != kind) || !matchesCustomPrefix(r, cfg.customPrefix) {
+					continue
+				}
+				if !send(apiEvent) {
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return out, cancel, nil
+}
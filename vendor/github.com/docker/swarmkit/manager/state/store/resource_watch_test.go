@@ -0,0 +1,67 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/swarmkit/api"
+)
+
+func TestMatchesCustomPrefix(t *testing.T) {
+	r := &api.Resource{Annotations: api.Annotations{
+		Indices: []api.IndexEntry{{Key: "tenant", Val: "acme/123"}},
+	}}
+	if !matchesCustomPrefix(r, "acme/") {
+		t.Error("expected prefix match")
+	}
+	if matchesCustomPrefix(r, "other/") {
+		t.Error("expected no match for unrelated prefix")
+	}
+	if !matchesCustomPrefix(r, "") {
+		t.Error("empty prefix should match everything")
+	}
+}
+
+// TestWatchResourcesByKindCancelDoesNotLeak makes sure cancel() unblocks
+// the forwarding goroutine even when the caller has stopped draining the
+// returned channel — a send in flight must not block forever.
+func TestWatchResourcesByKindCancelDoesNotLeak(t *testing.T) {
+	s := NewMemoryStore(nil)
+	const kind = "watched.kind"
+	if err := s.Update(func(tx Tx) error {
+		return CreateExtension(tx, &api.Extension{Annotations: api.Annotations{Name: kind}})
+	}); err != nil {
+		t.Fatalf("CreateExtension: %v", err)
+	}
+
+	events, cancel, err := WatchResourcesByKind(s, kind)
+	if err != nil {
+		t.Fatalf("WatchResourcesByKind: %v", err)
+	}
+
+	// Produce more events than anyone will ever read, then cancel without
+	// draining. If the forwarding goroutine doesn't select on the stop
+	// signal around its send, this deadlocks it permanently.
+	for i := 0; i < 8; i++ {
+		id := string(rune('a' + i))
+		if err := s.Update(func(tx Tx) error {
+			return CreateResource(tx, &api.Resource{ID: id, Annotations: api.Annotations{Name: id}, Kind: kind})
+		}); err != nil {
+			t.Fatalf("CreateResource: %v", err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		cancel()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancel() did not return; forwarding goroutine likely blocked on an undrained send")
+	}
+
+	_ = events
+}
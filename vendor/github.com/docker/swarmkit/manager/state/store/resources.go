@@ -1,6 +1,8 @@
 package store
 
 import (
+	"context"
+
 	"github.com/docker/swarmkit/api"
 	memdb "github.com/hashicorp/go-memdb"
 	"github.com/pkg/errors"
@@ -35,9 +37,27 @@ func init() {
 			},
 		},
 		Save: func(tx ReadTx, snapshot *api.StoreSnapshot) error {
-			var err error
-			snapshot.Resources, err = FindResources(tx, All)
-			return err
+			resources, err := FindResources(tx, All)
+			if err != nil {
+				return err
+			}
+			// A Resource offloaded to a *shared* driver (e.g. S3) stays
+			// offloaded in the snapshot: every node can reach the driver
+			// directly, so there's nothing to stream. A Resource offloaded
+			// to a node-local driver (filesystem, memory) has to be
+			// streamed back out of that driver and into the snapshot here,
+			// or a node restoring the snapshot elsewhere could never fetch
+			// it — the blob only ever existed on this node.
+			out := make([]*api.Resource, len(resources))
+			for i, r := range resources {
+				streamed, err := streamBlobForSnapshot(context.Background(), r)
+				if err != nil {
+					return err
+				}
+				out[i] = streamed
+			}
+			snapshot.Resources = out
+			return nil
 		},
 		Restore: func(tx Tx, snapshot *api.StoreSnapshot) error {
 			resources, err := FindResources(tx, All)
@@ -66,7 +86,19 @@ func init() {
 				case api.StoreActionKindUpdate:
 					return UpdateResource(tx, obj)
 				case api.StoreActionKindRemove:
-					return DeleteResource(tx, obj.ID)
+					// Read the raw (still-dehydrated) stored entry before
+					// deleting it: GetResource would hydrate the payload,
+					// which overwrites the blobRef we need to know what to
+					// garbage-collect.
+					removed := getRawResource(tx, obj.ID)
+					if err := DeleteResource(tx, obj.ID); err != nil {
+						return err
+					}
+					// The delete is already committed to this transaction;
+					// the blob itself lives outside raft, so garbage
+					// collecting it can safely happen after the fact and be
+					// retried if it fails.
+					return deleteResourceBlob(context.Background(), removed)
 				}
 			}
 			return errUnknownStoreAction
@@ -78,7 +110,7 @@ type resourceEntry struct {
 	*api.Resource
 }
 
-func confirmExtension(tx Tx, r *api.Resource) error {
+func confirmExtension(tx ReadTx, r *api.Resource) error {
 	// There must be an extension corresponding to the Kind field.
 	extensions, err := FindExtensions(tx, ByName(r.Kind))
 	if err != nil {
@@ -96,6 +128,15 @@ func CreateResource(tx Tx, r *api.Resource) error {
 	if err := confirmExtension(tx, r); err != nil {
 		return err
 	}
+	if err := detectDependencyCycle(tx, r); err != nil {
+		return err
+	}
+	if err := validateResourcePayload(tx, r); err != nil {
+		return err
+	}
+	if err := dehydrateResource(context.Background(), r); err != nil {
+		return err
+	}
 	return tx.create(tableResource, resourceEntry{r})
 }
 
@@ -105,7 +146,31 @@ func UpdateResource(tx Tx, r *api.Resource) error {
 	if err := confirmExtension(tx, r); err != nil {
 		return err
 	}
-	return tx.update(tableResource, resourceEntry{r})
+	if err := detectDependencyCycle(tx, r); err != nil {
+		return err
+	}
+	if err := validateResourcePayload(tx, r); err != nil {
+		return err
+	}
+	// Capture the pre-update stored entry before dehydrating the new
+	// payload, the same way ApplyStoreAction's remove path uses
+	// getRawResource before DeleteResource: if the old payload was
+	// offloaded to a blob that the new payload no longer references
+	// (re-offloaded under a different digest, or shrunk back under
+	// blobPayloadThreshold so it's no longer offloaded at all), that blob
+	// is now orphaned and has to be garbage-collected here or it leaks
+	// forever.
+	previous := getRawResource(tx, r.ID)
+	if err := dehydrateResource(context.Background(), r); err != nil {
+		return err
+	}
+	if err := tx.update(tableResource, resourceEntry{r}); err != nil {
+		return err
+	}
+	// The update is already committed to this transaction; the blob itself
+	// lives outside raft, so garbage collecting the now-stale one can
+	// safely happen after the fact and be retried if it fails.
+	return staleBlobFromUpdate(context.Background(), previous, r)
 }
 
 // DeleteResource removes a resource object from the store.
@@ -114,9 +179,11 @@ func DeleteResource(tx Tx, id string) error {
 	return tx.delete(tableResource, id)
 }
 
-// GetResource looks up a resource object by ID.
-// Returns nil if the object doesn't exist.
-func GetResource(tx ReadTx, id string) *api.Resource {
+// getRawResource looks up a resource object by ID exactly as it is stored,
+// without hydrating an offloaded Payload. Internal callers that need to
+// inspect the blobRef itself (e.g. to garbage-collect it) must use this
+// instead of GetResource.
+func getRawResource(tx ReadTx, id string) *api.Resource {
 	r := tx.get(tableResource, id)
 	if r == nil {
 		return nil
@@ -124,7 +191,32 @@ func GetResource(tx ReadTx, id string) *api.Resource {
 	return r.(resourceEntry).Resource
 }
 
-// FindResources selects a set of resource objects and returns them.
+// GetResource looks up a resource object by ID. The returned Resource is a
+// copy, with its Payload hydrated from its BlobStore if it was offloaded —
+// hydrating in place would overwrite the small blobRef in the table entry
+// itself with the full payload, which is exactly what this subsystem
+// exists to avoid.
+// Returns nil if the object doesn't exist.
+func GetResource(tx ReadTx, id string) *api.Resource {
+	stored := getRawResource(tx, id)
+	if stored == nil {
+		return nil
+	}
+	resource := stored.Copy()
+	// A hydration failure (e.g. the blob store is unreachable) is swallowed
+	// here rather than changing GetResource's signature; the caller gets
+	// the Resource back with its blobRef still in Payload instead of the
+	// real bytes, which is preferable to losing the metadata entirely.
+	_ = hydrateResource(context.Background(), resource)
+	return resource
+}
+
+// FindResources selects a set of resource objects and returns them. Unlike
+// GetResource, the Payload of an offloaded Resource is left as a blobRef;
+// callers that need the real bytes for a specific Resource should fetch it
+// with GetResource, or hydrate it themselves via hydrateResource. This
+// keeps bulk reads (including the snapshot path in Save) cheap even when
+// many Resources have large, offloaded payloads.
 func FindResources(tx ReadTx, by By) ([]*api.Resource, error) {
 	checkType := func(by By) error {
 		switch by.(type) {